@@ -0,0 +1,371 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parser parses Blueprint's `.bp` module-definition syntax into a
+// tree of Expression and Definition values for proptools.UnpackProperties to
+// walk. A file is a sequence of top-level variable assignments
+// (`name = value`) and module definitions (`type { name: value, ... }`);
+// values are string, bool, list and map literals, or a reference to a
+// variable assigned earlier in the file. ParseAndEval resolves those
+// references as it parses, so the returned tree never contains a variable
+// reference itself, only the literal it resolved to.
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/scanner"
+)
+
+// Expression is implemented by every value a property or variable may hold:
+// *String, *Bool, *List and *Map.
+type Expression interface {
+	// Pos returns the position of the expression's first token.
+	Pos() scanner.Position
+	// Type returns the expression's type as a human-readable word, for use
+	// in error messages (e.g. "string", "list").
+	Type() string
+}
+
+// String is a double-quoted string literal.
+type String struct {
+	LiteralPos scanner.Position
+	Value      string
+}
+
+func (s *String) Pos() scanner.Position { return s.LiteralPos }
+func (s *String) Type() string          { return "string" }
+
+// Bool is the literal `true` or `false`.
+type Bool struct {
+	LiteralPos scanner.Position
+	Value      bool
+}
+
+func (b *Bool) Pos() scanner.Position { return b.LiteralPos }
+func (b *Bool) Type() string          { return "bool" }
+
+// List is a `[value, value, ...]` literal. Blueprint only allows list
+// elements that are themselves strings, but List does not enforce that
+// itself; proptools.UnpackProperties is what rejects other element types.
+type List struct {
+	LBracePos scanner.Position
+	RBracePos scanner.Position
+	Values    []Expression
+}
+
+func (l *List) Pos() scanner.Position { return l.LBracePos }
+func (l *List) Type() string          { return "list" }
+
+// Property is a single `name: value` entry of a Map or Module.
+type Property struct {
+	Name     string
+	NamePos  scanner.Position
+	ColonPos scanner.Position
+	Value    Expression
+}
+
+// Map is a `{ name: value, ... }` literal.
+type Map struct {
+	LBracePos  scanner.Position
+	RBracePos  scanner.Position
+	Properties []*Property
+}
+
+func (m *Map) Pos() scanner.Position { return m.LBracePos }
+func (m *Map) Type() string          { return "map" }
+
+// Definition is implemented by every top-level element of a parsed File:
+// *Module and *Assignment.
+type Definition interface {
+	Pos() scanner.Position
+}
+
+// Module is a top-level `type { name: value, ... }` block.
+type Module struct {
+	Type       string
+	TypePos    scanner.Position
+	LBracePos  scanner.Position
+	RBracePos  scanner.Position
+	Properties []*Property
+}
+
+func (m *Module) Pos() scanner.Position { return m.TypePos }
+
+// Assignment is a top-level `name = value` variable declaration.
+type Assignment struct {
+	Name    string
+	NamePos scanner.Position
+	Value   Expression
+}
+
+func (a *Assignment) Pos() scanner.Position { return a.NamePos }
+
+// File is the result of parsing a single `.bp` file.
+type File struct {
+	Name string
+	Defs []Definition
+}
+
+// Scope holds the variables visible while evaluating a file. NewScope(nil)
+// is the common case of a file with no variables inherited from a parent
+// scope.
+type Scope struct {
+	parent *Scope
+	vars   map[string]Expression
+}
+
+// NewScope returns a new Scope whose variables are looked up in parent, if
+// given, when not found locally.
+func NewScope(parent *Scope) *Scope {
+	return &Scope{parent: parent, vars: make(map[string]Expression)}
+}
+
+// Get returns the value most recently assigned to name in s or one of its
+// parents, and whether such an assignment exists.
+func (s *Scope) Get(name string) (Expression, bool) {
+	for cur := s; cur != nil; cur = cur.parent {
+		if v, ok := cur.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Set records name as referring to value for the remainder of s.
+func (s *Scope) Set(name string, value Expression) {
+	s.vars[name] = value
+}
+
+// ParseError is returned for every syntax error ParseAndEval encounters; Pos
+// is the position at which the error was detected.
+type ParseError struct {
+	Err error
+	Pos scanner.Position
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Err)
+}
+
+// ParseAndEval parses the `.bp` syntax read from r, resolving variable
+// references against scope as it goes, and returns the resulting File along
+// with every error encountered. filename is recorded in the returned
+// positions and in File.Name; it does not need to refer to a real file.
+func ParseAndEval(filename string, r io.Reader, scope *Scope) (*File, []error) {
+	p := newParser(filename, r, scope)
+	defs := p.parseFile()
+	return &File{Name: filename, Defs: defs}, p.errors
+}
+
+type parser struct {
+	scanner scanner.Scanner
+	scope   *Scope
+	errors  []error
+	tok     rune
+}
+
+func newParser(filename string, r io.Reader, scope *Scope) *parser {
+	p := &parser{scope: scope}
+	p.scanner.Init(r)
+	p.scanner.Filename = filename
+	p.scanner.Mode = scanner.ScanIdents | scanner.ScanStrings | scanner.ScanComments | scanner.SkipComments
+	p.scanner.Error = func(_ *scanner.Scanner, msg string) {
+		p.errorf("%s", msg)
+	}
+	p.next()
+	return p
+}
+
+func (p *parser) next() {
+	p.tok = p.scanner.Scan()
+}
+
+func (p *parser) pos() scanner.Position {
+	return p.scanner.Position
+}
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	p.errors = append(p.errors, &ParseError{fmt.Errorf(format, args...), p.pos()})
+}
+
+func (p *parser) tokenText() string {
+	switch p.tok {
+	case scanner.EOF:
+		return "EOF"
+	case scanner.Ident, scanner.String:
+		return p.scanner.TokenText()
+	default:
+		return string(p.tok)
+	}
+}
+
+func (p *parser) parseFile() []Definition {
+	var defs []Definition
+	for p.tok != scanner.EOF {
+		if p.tok != scanner.Ident {
+			p.errorf("expected variable assignment or module definition, found %q", p.tokenText())
+			p.next()
+			continue
+		}
+
+		name := p.scanner.TokenText()
+		namePos := p.pos()
+		p.next()
+
+		switch p.tok {
+		case '=':
+			p.next()
+			value := p.parseExpression()
+			if value == nil {
+				continue
+			}
+			p.scope.Set(name, value)
+			defs = append(defs, &Assignment{Name: name, NamePos: namePos, Value: value})
+		case '{':
+			lbrace, props, rbrace := p.parseBraceProperties()
+			defs = append(defs, &Module{
+				Type:       name,
+				TypePos:    namePos,
+				LBracePos:  lbrace,
+				RBracePos:  rbrace,
+				Properties: props,
+			})
+		default:
+			p.errorf("expected '=' or '{' after %q, found %q", name, p.tokenText())
+		}
+	}
+	return defs
+}
+
+// parseBraceProperties parses a `{ name: value, ... }` block; p.tok must be
+// '{' on entry.
+func (p *parser) parseBraceProperties() (lbrace scanner.Position, props []*Property, rbrace scanner.Position) {
+	lbrace = p.pos()
+	p.next() // consume '{'
+
+	for p.tok != '}' && p.tok != scanner.EOF {
+		if p.tok != scanner.Ident {
+			p.errorf("expected property name, found %q", p.tokenText())
+			p.next()
+			continue
+		}
+
+		propName := p.scanner.TokenText()
+		propNamePos := p.pos()
+		p.next()
+
+		if p.tok != ':' {
+			p.errorf("expected ':' after %q, found %q", propName, p.tokenText())
+			continue
+		}
+		colonPos := p.pos()
+		p.next()
+
+		value := p.parseExpression()
+		if value != nil {
+			props = append(props, &Property{
+				Name:     propName,
+				NamePos:  propNamePos,
+				ColonPos: colonPos,
+				Value:    value,
+			})
+		}
+
+		if p.tok == ',' {
+			p.next()
+		} else if p.tok != '}' {
+			p.errorf("expected ',' or '}', found %q", p.tokenText())
+		}
+	}
+
+	rbrace = p.pos()
+	if p.tok == '}' {
+		p.next()
+	} else {
+		p.errorf("expected '}', found %q", p.tokenText())
+	}
+	return lbrace, props, rbrace
+}
+
+func (p *parser) parseExpression() Expression {
+	switch p.tok {
+	case scanner.String:
+		pos := p.pos()
+		value, err := strconv.Unquote(p.scanner.TokenText())
+		if err != nil {
+			p.errorf("invalid string literal: %s", err)
+			return nil
+		}
+		p.next()
+		return &String{LiteralPos: pos, Value: value}
+
+	case scanner.Ident:
+		name := p.scanner.TokenText()
+		pos := p.pos()
+		p.next()
+		switch name {
+		case "true", "false":
+			return &Bool{LiteralPos: pos, Value: name == "true"}
+		default:
+			value, ok := p.scope.Get(name)
+			if !ok {
+				p.errorf("undefined variable %q", name)
+				return nil
+			}
+			return value
+		}
+
+	case '[':
+		return p.parseList()
+
+	case '{':
+		lbrace, props, rbrace := p.parseBraceProperties()
+		return &Map{LBracePos: lbrace, RBracePos: rbrace, Properties: props}
+
+	default:
+		p.errorf("expected a value, found %q", p.tokenText())
+		p.next()
+		return nil
+	}
+}
+
+func (p *parser) parseList() *List {
+	lbrace := p.pos()
+	p.next() // consume '['
+
+	var values []Expression
+	for p.tok != ']' && p.tok != scanner.EOF {
+		value := p.parseExpression()
+		if value != nil {
+			values = append(values, value)
+		}
+		if p.tok == ',' {
+			p.next()
+		} else if p.tok != ']' {
+			p.errorf("expected ',' or ']', found %q", p.tokenText())
+			break
+		}
+	}
+
+	rbrace := p.pos()
+	if p.tok == ']' {
+		p.next()
+	} else {
+		p.errorf("expected ']', found %q", p.tokenText())
+	}
+
+	return &List{LBracePos: lbrace, RBracePos: rbrace, Values: values}
+}
@@ -0,0 +1,132 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import "reflect"
+
+// CloneProperties returns a deep copy of a property struct, including any
+// property structs stored in interface{} fields that were placed there by
+// UnpackProperties. structValue may be either the struct itself or a pointer
+// to it; the result is always a pointer to a new struct.
+func CloneProperties(structValue reflect.Value) reflect.Value {
+	structValue = indirect(structValue)
+	result := reflect.New(structValue.Type())
+	cloneValueInto(result.Elem(), structValue)
+	return result
+}
+
+// CloneEmptyProperties returns a new zero-valued property struct of the same
+// type as structValue, with any interface{} fields that hold a property
+// struct (directly or through non-nil pointers) replaced by a pointer to a
+// new zero-valued instance of that struct type (rather than left nil); an
+// interface holding a nil pointer is left nil. The result can be used as a destination
+// for UnpackProperties. structValue may be either the struct itself or a
+// pointer to it; the result is always a pointer to a new struct.
+func CloneEmptyProperties(structValue reflect.Value) reflect.Value {
+	structValue = indirect(structValue)
+	result := reflect.New(structValue.Type())
+	cloneEmptyValueInto(result.Elem(), structValue)
+	return result
+}
+
+func cloneValueInto(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Struct:
+		srcType := src.Type()
+		for i := 0; i < src.NumField(); i++ {
+			if srcType.Field(i).PkgPath != "" {
+				// Unexported field.
+				continue
+			}
+			cloneValueInto(dst.Field(i), src.Field(i))
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			cloneValueInto(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		for _, k := range src.MapKeys() {
+			v := reflect.New(src.Type().Elem()).Elem()
+			cloneValueInto(v, src.MapIndex(k))
+			dst.SetMapIndex(k, v)
+		}
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.New(src.Type().Elem()))
+		cloneValueInto(dst.Elem(), src.Elem())
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		elem := src.Elem()
+		for elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				dst.Set(elem)
+				return
+			}
+			newElem := reflect.New(elem.Type().Elem())
+			cloneValueInto(newElem.Elem(), elem.Elem())
+			dst.Set(newElem)
+			return
+		}
+		newElem := reflect.New(elem.Type()).Elem()
+		cloneValueInto(newElem, elem)
+		dst.Set(newElem)
+	default:
+		dst.Set(src)
+	}
+}
+
+func cloneEmptyValueInto(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Struct:
+		srcType := src.Type()
+		for i := 0; i < src.NumField(); i++ {
+			if srcType.Field(i).PkgPath != "" {
+				// Unexported field.
+				continue
+			}
+			cloneEmptyValueInto(dst.Field(i), src.Field(i))
+		}
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		elem := src.Elem()
+		for elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				return
+			}
+			elem = elem.Elem()
+		}
+		newElem := reflect.New(elem.Type())
+		cloneEmptyValueInto(newElem.Elem(), elem)
+		dst.Set(newElem)
+	default:
+		// Leave all other kinds zero-valued; UnpackProperties will
+		// allocate pointers, slices and maps as it encounters values
+		// for them.
+	}
+}
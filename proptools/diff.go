@@ -0,0 +1,465 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PatchOp identifies how a single PatchEntry should be applied.
+type PatchOp string
+
+const (
+	// PatchSet replaces a scalar or pointer field outright. Old and New
+	// hold the two sides as interface{}; a nil *string/*bool is reported
+	// as the untyped nil, distinct from a pointer to the zero value, the
+	// same way UnpackProperties treats "not set" as different from "set to
+	// the zero value".
+	PatchSet PatchOp = "set"
+
+	// PatchAppendList records that the elements in Added were appended to
+	// the end of a []string field's previous contents, mirroring the merge
+	// UnpackProperties itself performs when applying a chain of `defaults`.
+	PatchAppendList PatchOp = "appendList"
+
+	// PatchReplaceList records that a []string field took on an entirely
+	// different list, held in Added, one that isn't simply the previous
+	// contents with elements appended.
+	PatchReplaceList PatchOp = "replaceList"
+
+	// PatchNested records that a struct- or map-valued field changed; the
+	// details are in the entry's Nested sub-patch.
+	PatchNested PatchOp = "nested"
+)
+
+// PatchEntry describes the change to a single property-struct field or map
+// key.
+type PatchEntry struct {
+	Op PatchOp
+
+	// Old and New hold the two sides of a PatchSet.
+	Old interface{}
+	New interface{}
+
+	// Added holds the elements appended to the end of a []string field for
+	// a PatchAppendList, or the field's new contents for a PatchReplaceList.
+	Added []string
+
+	// Nested holds the sub-patch for a PatchNested entry.
+	Nested Patch
+}
+
+// Patch is a tree of field (or, for a map field, key) names to the change
+// at that path. A field with no entry didn't change. It is produced by Diff
+// and consumed by Apply, and is compact enough to serialize and store
+// alongside a previous unpack so a driver can later decide which modules
+// need to be re-analyzed after reparsing a single `.bp` file.
+type Patch map[string]PatchEntry
+
+// Diff compares two property structs of the same type, as produced by
+// UnpackProperties, and returns the changes needed to turn a into b. It
+// walks the same reflection tree UnpackProperties fills: unexported and
+// `blueprint:"mutated"` fields are ignored, and anonymous (embedded) struct
+// and interface fields are flattened into the same Patch as their
+// containing struct.
+func Diff(a, b interface{}) Patch {
+	av := dereference(reflect.ValueOf(a))
+	bv := dereference(reflect.ValueOf(b))
+	if av.Type() != bv.Type() {
+		panic(fmt.Errorf("proptools.Diff: %s and %s are not the same type", av.Type(), bv.Type()))
+	}
+	return diffStruct(av, bv)
+}
+
+// Apply mutates dst, a pointer to a property struct, applying every change
+// in p. It returns an error if an entry can't be applied to the field or map
+// key it names, such as a nested patch for an unset interface field or a
+// field of an unsupported type. Entries naming a field that doesn't exist in
+// dst's type are ignored.
+func Apply(dst interface{}, p Patch) error {
+	return applyStruct(p, dereference(reflect.ValueOf(dst)))
+}
+
+func diffStruct(a, b reflect.Value) Patch {
+	patch := Patch{}
+	structType := a.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" || HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		if field.Anonymous {
+			for name, entry := range diffValue(a.Field(i), b.Field(i)) {
+				patch[name] = entry
+			}
+			continue
+		}
+
+		name := PropertyNameForField(field.Name)
+		if entry, changed := diffFieldValue(a.Field(i), b.Field(i)); changed {
+			patch[name] = entry
+		}
+	}
+
+	return patch
+}
+
+// diffValue diffs an anonymous embedded field, returning the flattened
+// Patch fragment it contributes to its containing struct rather than a
+// PatchEntry of its own.
+func diffValue(a, b reflect.Value) Patch {
+	if a.Kind() == reflect.Interface {
+		if a.IsNil() || b.IsNil() {
+			// Nothing sensible to flatten a diff out of without a shared
+			// concrete type on both sides; treat as unchanged since this
+			// mirrors UnpackProperties only ever widening these fields.
+			return Patch{}
+		}
+		return diffStruct(indirect(a.Elem()), indirect(b.Elem()))
+	}
+	return diffStruct(a, b)
+}
+
+// diffFieldValue diffs a single named field, returning its PatchEntry and
+// whether anything actually changed.
+func diffFieldValue(a, b reflect.Value) (PatchEntry, bool) {
+	switch a.Kind() {
+	case reflect.String, reflect.Bool:
+		if a.Interface() == b.Interface() {
+			return PatchEntry{}, false
+		}
+		return PatchEntry{Op: PatchSet, Old: a.Interface(), New: b.Interface()}, true
+
+	case reflect.Ptr:
+		switch a.Type().Elem().Kind() {
+		case reflect.Struct:
+			if a.IsNil() && b.IsNil() {
+				return PatchEntry{}, false
+			}
+			var nested Patch
+			switch {
+			case a.IsNil():
+				nested = diffStruct(reflect.New(a.Type().Elem()).Elem(), b.Elem())
+			case b.IsNil():
+				return PatchEntry{Op: PatchSet, New: nil}, true
+			default:
+				nested = diffStruct(a.Elem(), b.Elem())
+			}
+			if len(nested) == 0 {
+				return PatchEntry{}, false
+			}
+			return PatchEntry{Op: PatchNested, Nested: nested}, true
+
+		default:
+			av, bv := ptrInterface(a), ptrInterface(b)
+			if av == bv {
+				return PatchEntry{}, false
+			}
+			return PatchEntry{Op: PatchSet, Old: av, New: bv}, true
+		}
+
+	case reflect.Slice:
+		aList, bList := stringSlice(a), stringSlice(b)
+		return diffStringSlice(aList, bList)
+
+	case reflect.Struct:
+		nested := diffStruct(a, b)
+		if len(nested) == 0 {
+			return PatchEntry{}, false
+		}
+		return PatchEntry{Op: PatchNested, Nested: nested}, true
+
+	case reflect.Map:
+		nested := diffMap(a, b)
+		if len(nested) == 0 {
+			return PatchEntry{}, false
+		}
+		return PatchEntry{Op: PatchNested, Nested: nested}, true
+
+	case reflect.Interface:
+		if a.IsNil() && b.IsNil() {
+			return PatchEntry{}, false
+		}
+		if a.IsNil() != b.IsNil() {
+			return PatchEntry{Op: PatchSet, Old: ifaceInterface(a), New: ifaceInterface(b)}, true
+		}
+		nested := diffStruct(indirect(a.Elem()), indirect(b.Elem()))
+		if len(nested) == 0 {
+			return PatchEntry{}, false
+		}
+		return PatchEntry{Op: PatchNested, Nested: nested}, true
+
+	default:
+		panic(fmt.Errorf("proptools.Diff: unsupported property type %s", a.Type()))
+	}
+}
+
+func diffMap(a, b reflect.Value) Patch {
+	patch := Patch{}
+	seen := map[string]bool{}
+	elemType := a.Type().Elem()
+
+	for _, key := range a.MapKeys() {
+		seen[key.String()] = true
+		aValue := a.MapIndex(key)
+		bValue := b.MapIndex(key)
+		if !bValue.IsValid() {
+			// Key removed: there's no "new" side to diff against, so just
+			// record the deletion directly; applyMap treats a PatchSet
+			// with a nil New as a sentinel to remove the key outright,
+			// regardless of the element's own kind.
+			patch[key.String()] = PatchEntry{Op: PatchSet, Old: mapElemInterface(aValue), New: nil}
+			continue
+		}
+		if entry, changed := diffFieldValue(aValue, bValue); changed {
+			patch[key.String()] = entry
+		}
+	}
+	for _, key := range b.MapKeys() {
+		if seen[key.String()] {
+			continue
+		}
+		// Key added: diff against a zero value of the element type, the
+		// same way diffFieldValue already does for a newly-populated
+		// *struct field. That gives an added key the same per-kind
+		// PatchSet/PatchAppendList/PatchNested shape a changed key would
+		// get, instead of a blanket PatchSet that only round-trips through
+		// Apply for plain string/bool elements.
+		zero := reflect.New(elemType).Elem()
+		if entry, changed := diffFieldValue(zero, b.MapIndex(key)); changed {
+			patch[key.String()] = entry
+		}
+	}
+
+	return patch
+}
+
+// mapElemInterface returns the flat representation of a removed map value
+// for PatchEntry.Old, mirroring what diffFieldValue would have put there had
+// the value changed rather than disappeared. Struct, slice and map values
+// have no such flat form - the corresponding PatchOp never stores them in
+// Old/New either - so Old is left nil for those.
+func mapElemInterface(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.String, reflect.Bool:
+		return v.Interface()
+	case reflect.Ptr:
+		return ptrInterface(v)
+	case reflect.Interface:
+		return ifaceInterface(v)
+	default:
+		return nil
+	}
+}
+
+// diffStringSlice reports an append when b is exactly a with more elements
+// on the end (the shape UnpackProperties itself produces when merging a
+// `defaults` chain), and a full replacement otherwise.
+func diffStringSlice(a, b []string) (PatchEntry, bool) {
+	if stringSliceEqual(a, b) {
+		return PatchEntry{}, false
+	}
+	if len(b) > len(a) && stringSliceEqual(a, b[:len(a)]) {
+		return PatchEntry{Op: PatchAppendList, Added: append([]string(nil), b[len(a):]...)}, true
+	}
+	return PatchEntry{Op: PatchReplaceList, Added: append([]string(nil), b...)}, true
+}
+
+func stringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringSlice(v reflect.Value) []string {
+	if v.IsNil() {
+		return nil
+	}
+	result := make([]string, v.Len())
+	for i := range result {
+		result[i] = v.Index(i).String()
+	}
+	return result
+}
+
+// ptrInterface returns nil for a nil pointer, or the pointed-to value for
+// one that isn't, so PatchSet can tell "not set" apart from "set to the
+// zero value" the same way UnpackProperties does.
+func ptrInterface(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Elem().Interface()
+}
+
+func ifaceInterface(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func applyStruct(p Patch, structValue reflect.Value) error {
+	structType := structValue.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" || HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		if field.Anonymous {
+			if err := applyEmbedded(p, structValue.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry, ok := p[PropertyNameForField(field.Name)]
+		if !ok {
+			continue
+		}
+		if err := applyFieldValue(entry, structValue.Field(i)); err != nil {
+			return fmt.Errorf("field %s: %s", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyEmbedded(p Patch, fieldValue reflect.Value) error {
+	if fieldValue.Kind() == reflect.Interface {
+		if fieldValue.IsNil() {
+			return fmt.Errorf("can't apply a patch to an unset embedded interface field")
+		}
+		return applyStruct(p, indirect(fieldValue.Elem()))
+	}
+	return applyStruct(p, fieldValue)
+}
+
+func applyFieldValue(entry PatchEntry, fieldValue reflect.Value) error {
+	switch entry.Op {
+	case PatchSet:
+		return applySet(entry, fieldValue)
+
+	case PatchAppendList:
+		result := fieldValue
+		if fieldValue.IsNil() {
+			result = reflect.MakeSlice(fieldValue.Type(), 0, len(entry.Added))
+		}
+		for _, s := range entry.Added {
+			result = reflect.Append(result, reflect.ValueOf(s))
+		}
+		fieldValue.Set(result)
+		return nil
+
+	case PatchReplaceList:
+		fieldValue.Set(reflect.ValueOf(append([]string(nil), entry.Added...)))
+		return nil
+
+	case PatchNested:
+		switch fieldValue.Kind() {
+		case reflect.Struct:
+			return applyStruct(entry.Nested, fieldValue)
+		case reflect.Ptr:
+			if fieldValue.IsNil() {
+				fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+			}
+			return applyStruct(entry.Nested, fieldValue.Elem())
+		case reflect.Map:
+			return applyMap(entry.Nested, fieldValue)
+		case reflect.Interface:
+			if fieldValue.IsNil() {
+				return fmt.Errorf("can't apply a nested patch to an unset interface field")
+			}
+			return applyStruct(entry.Nested, indirect(fieldValue.Elem()))
+		default:
+			return fmt.Errorf("unsupported property type %s for a nested patch", fieldValue.Type())
+		}
+
+	default:
+		return fmt.Errorf("unknown patch operation %q", entry.Op)
+	}
+}
+
+func applySet(entry PatchEntry, fieldValue reflect.Value) error {
+	switch fieldValue.Kind() {
+	case reflect.String:
+		s, _ := entry.New.(string)
+		fieldValue.SetString(s)
+	case reflect.Bool:
+		b, _ := entry.New.(bool)
+		fieldValue.SetBool(b)
+	case reflect.Ptr:
+		if entry.New == nil {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+			return nil
+		}
+		switch fieldValue.Type().Elem().Kind() {
+		case reflect.String:
+			s, _ := entry.New.(string)
+			fieldValue.Set(reflect.ValueOf(StringPtr(s)))
+		case reflect.Bool:
+			b, _ := entry.New.(bool)
+			fieldValue.Set(reflect.ValueOf(BoolPtr(b)))
+		default:
+			return fmt.Errorf("unsupported pointer property type %s", fieldValue.Type())
+		}
+	case reflect.Interface:
+		if entry.New == nil {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+			return nil
+		}
+		return fmt.Errorf("can't apply a set patch to an untyped interface field")
+	default:
+		return fmt.Errorf("unsupported property type %s for a set patch", fieldValue.Type())
+	}
+	return nil
+}
+
+func applyMap(p Patch, fieldValue reflect.Value) error {
+	if fieldValue.IsNil() {
+		fieldValue.Set(reflect.MakeMapWithSize(fieldValue.Type(), len(p)))
+	}
+
+	for key, entry := range p {
+		keyValue := reflect.ValueOf(key)
+
+		if entry.Op == PatchSet && entry.New == nil {
+			fieldValue.SetMapIndex(keyValue, reflect.Value{})
+			continue
+		}
+
+		elem := reflect.New(fieldValue.Type().Elem()).Elem()
+		if existing := fieldValue.MapIndex(keyValue); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := applyFieldValue(entry, elem); err != nil {
+			return fmt.Errorf("key %q: %s", key, err)
+		}
+		fieldValue.SetMapIndex(keyValue, elem)
+	}
+
+	return nil
+}
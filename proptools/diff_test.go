@@ -0,0 +1,346 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+)
+
+type diffTestProperties struct {
+	Name   string
+	Vendor *string
+	Srcs   []string
+	Nested struct {
+		Foo string
+		Bar *string
+	}
+	NestedPtr *struct {
+		Baz string
+	}
+	Iface   interface{}
+	Mutated string `blueprint:"mutated"`
+	Dict    map[string]string
+	PtrDict map[string]*string
+	ListMap map[string][]string
+	Arch    map[string]struct {
+		Cflags []string
+	}
+}
+
+func unpackDiffTestModule(t *testing.T, input string) *diffTestProperties {
+	t.Helper()
+
+	properties := parseModuleProperties(t, input)
+	props := &diffTestProperties{}
+	if _, errs := UnpackProperties(properties, props); len(errs) != 0 {
+		t.Fatalf("unexpected unpack errors: %v", errs)
+	}
+	return props
+}
+
+func TestDiffNoOp(t *testing.T) {
+	const input = `
+		m {
+			name: "libfoo",
+			vendor: "acme",
+			srcs: ["a.c", "b.c"],
+			nested: {
+				foo: "bar",
+			},
+		}
+	`
+
+	a := unpackDiffTestModule(t, input)
+	b := unpackDiffTestModule(t, input)
+
+	patch := Diff(a, b)
+	if len(patch) != 0 {
+		t.Errorf("expected an empty patch for two unpacks of the same input, got %+v", patch)
+	}
+}
+
+func TestDiffApplyScalarAndPointer(t *testing.T) {
+	a := unpackDiffTestModule(t, `
+		m {
+			name: "libfoo",
+			vendor: "acme",
+		}
+	`)
+	b := unpackDiffTestModule(t, `
+		m {
+			name: "libbar",
+		}
+	`)
+
+	patch := Diff(a, b)
+
+	got := unpackDiffTestModule(t, `
+		m {
+			name: "libfoo",
+			vendor: "acme",
+		}
+	`)
+	if err := Apply(got, patch); err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("Apply(a, Diff(a, b)) != b:\n got:  %+v\n want: %+v", got, b)
+	}
+}
+
+func TestDiffApplyNestedStruct(t *testing.T) {
+	a := unpackDiffTestModule(t, `
+		m {
+			name: "libfoo",
+			nested: {
+				foo: "one",
+			},
+		}
+	`)
+	b := unpackDiffTestModule(t, `
+		m {
+			name: "libfoo",
+			nested: {
+				foo: "two",
+				bar: "three",
+			},
+		}
+	`)
+
+	patch := Diff(a, b)
+	if _, ok := patch["nested"]; !ok {
+		t.Fatalf("expected a nested patch entry for %q, got %+v", "nested", patch)
+	}
+	if patch["nested"].Op != PatchNested {
+		t.Errorf("expected nested field to use PatchNested, got %s", patch["nested"].Op)
+	}
+
+	got := unpackDiffTestModule(t, `
+		m {
+			name: "libfoo",
+			nested: {
+				foo: "one",
+			},
+		}
+	`)
+	if err := Apply(got, patch); err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("Apply(a, Diff(a, b)) != b:\n got:  %+v\n want: %+v", got, b)
+	}
+}
+
+func TestDiffApplyPointerToStruct(t *testing.T) {
+	a := unpackDiffTestModule(t, `m { name: "libfoo" } `)
+	b := unpackDiffTestModule(t, `
+		m {
+			name: "libfoo",
+			nestedPtr: {
+				baz: "qux",
+			},
+		}
+	`)
+
+	patch := Diff(a, b)
+
+	got := unpackDiffTestModule(t, `m { name: "libfoo" } `)
+	if err := Apply(got, patch); err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("Apply(a, Diff(a, b)) != b:\n got:  %+v\n want: %+v", got, b)
+	}
+}
+
+func TestDiffListAppendVsReplace(t *testing.T) {
+	a := unpackDiffTestModule(t, `m { srcs: ["a.c", "b.c"] } `)
+	appended := unpackDiffTestModule(t, `m { srcs: ["a.c", "b.c", "c.c"] } `)
+	replaced := unpackDiffTestModule(t, `m { srcs: ["x.c"] } `)
+
+	appendPatch := Diff(a, appended)
+	if appendPatch["srcs"].Op != PatchAppendList {
+		t.Errorf("expected an appendList patch, got %+v", appendPatch["srcs"])
+	}
+	if got := appendPatch["srcs"].Added; !reflect.DeepEqual(got, []string{"c.c"}) {
+		t.Errorf("expected only the appended element, got %v", got)
+	}
+
+	replacePatch := Diff(a, replaced)
+	if replacePatch["srcs"].Op != PatchReplaceList {
+		t.Errorf("expected a replaceList patch, got %+v", replacePatch["srcs"])
+	}
+
+	got := unpackDiffTestModule(t, `m { srcs: ["a.c", "b.c"] } `)
+	if err := Apply(got, appendPatch); err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, appended) {
+		t.Errorf("Apply(a, Diff(a, appended)) != appended:\n got:  %+v\n want: %+v", got, appended)
+	}
+
+	got = unpackDiffTestModule(t, `m { srcs: ["a.c", "b.c"] } `)
+	if err := Apply(got, replacePatch); err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, replaced) {
+		t.Errorf("Apply(a, Diff(a, replaced)) != replaced:\n got:  %+v\n want: %+v", got, replaced)
+	}
+}
+
+func TestDiffApplyInterfaceField(t *testing.T) {
+	a := &diffTestProperties{Iface: &EmbeddedStruct{S: "one"}}
+	b := &diffTestProperties{Iface: &EmbeddedStruct{S: "two"}}
+
+	patch := Diff(a, b)
+	if patch["iface"].Op != PatchNested {
+		t.Errorf("expected a nested patch for a changed interface field, got %+v", patch["iface"])
+	}
+
+	got := &diffTestProperties{Iface: &EmbeddedStruct{S: "one"}}
+	if err := Apply(got, patch); err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("Apply(a, Diff(a, b)) != b:\n got:  %+v\n want: %+v", got, b)
+	}
+}
+
+func TestDiffApplyMapKeyAdded(t *testing.T) {
+	const before = `
+		m {
+			dict: {
+				foo: "one",
+			},
+			ptrDict: {
+				foo: "one",
+			},
+			listMap: {
+				foo: ["a"],
+			},
+			arch: {
+				arm: {
+					cflags: ["-DARM"],
+				},
+			},
+		}
+	`
+	const after = `
+		m {
+			dict: {
+				foo: "one",
+				bar: "two",
+			},
+			ptrDict: {
+				foo: "one",
+				bar: "two",
+			},
+			listMap: {
+				foo: ["a"],
+				bar: ["b"],
+			},
+			arch: {
+				arm: {
+					cflags: ["-DARM"],
+				},
+				arm64: {
+					cflags: ["-DARM64"],
+				},
+			},
+		}
+	`
+
+	a := unpackDiffTestModule(t, before)
+	b := unpackDiffTestModule(t, after)
+
+	patch := Diff(a, b)
+
+	got := unpackDiffTestModule(t, before)
+	if err := Apply(got, patch); err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("Apply(a, Diff(a, b)) != b:\n got:  %+v\n want: %+v", got, b)
+	}
+}
+
+func TestDiffApplyMapKeyRemoved(t *testing.T) {
+	const before = `
+		m {
+			dict: {
+				foo: "one",
+				bar: "two",
+			},
+			ptrDict: {
+				foo: "one",
+				bar: "two",
+			},
+			listMap: {
+				foo: ["a"],
+				bar: ["b"],
+			},
+			arch: {
+				arm: {
+					cflags: ["-DARM"],
+				},
+				arm64: {
+					cflags: ["-DARM64"],
+				},
+			},
+		}
+	`
+	const after = `
+		m {
+			dict: {
+				foo: "one",
+			},
+			ptrDict: {
+				foo: "one",
+			},
+			listMap: {
+				foo: ["a"],
+			},
+			arch: {
+				arm: {
+					cflags: ["-DARM"],
+				},
+			},
+		}
+	`
+
+	a := unpackDiffTestModule(t, before)
+	b := unpackDiffTestModule(t, after)
+
+	patch := Diff(a, b)
+
+	got := unpackDiffTestModule(t, before)
+	if err := Apply(got, patch); err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("Apply(a, Diff(a, b)) != b:\n got:  %+v\n want: %+v", got, b)
+	}
+}
+
+func TestDiffIgnoresMutatedField(t *testing.T) {
+	a := &diffTestProperties{Mutated: "one"}
+	b := &diffTestProperties{Mutated: "two"}
+
+	if patch := Diff(a, b); len(patch) != 0 {
+		t.Errorf("expected a mutated field to be excluded from the patch, got %+v", patch)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldFilter reports whether a struct field of a nested property struct is
+// allowed to be unpacked into, as restricted by a `blueprint:"filter(...)"`
+// tag on the field that contains it.
+type fieldFilter func(field reflect.StructField) bool
+
+// HasTag returns whether field has a struct tag of the form
+// `name:"value,..."` with value as one of the comma-separated items.
+func HasTag(field reflect.StructField, name, value string) bool {
+	tag := field.Tag.Get(name)
+	for _, entry := range strings.Split(tag, ",") {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+// filterForField parses the `blueprint:"filter(key:\"value\")"` tag on
+// field, if present, into a fieldFilter that only allows through nested
+// fields carrying a matching `key:"value"` tag of their own. A field with no
+// such tag has no filter, so every nested field is allowed.
+func filterForField(field reflect.StructField) (fieldFilter, error) {
+	tag := field.Tag.Get("blueprint")
+	const prefix = "filter("
+	const suffix = ")"
+
+	for _, entry := range strings.Split(tag, ",") {
+		if !strings.HasPrefix(entry, prefix) || !strings.HasSuffix(entry, suffix) {
+			continue
+		}
+		inner := entry[len(prefix) : len(entry)-len(suffix)]
+		colon := strings.Index(inner, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid filter tag %q on field %s", tag, field.Name)
+		}
+		key := inner[:colon]
+		value, err := parseQuoted(inner[colon+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter tag %q on field %s: %s", tag, field.Name, err)
+		}
+
+		return func(nestedField reflect.StructField) bool {
+			return HasTag(nestedField, key, value)
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func parseQuoted(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("expected quoted string, got %q", s)
+	}
+	return s[1 : len(s)-1], nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/blueprint/parser"
+)
+
+// unpackMap fills a map[string]T field from a `{ key: value, ... }` literal.
+// T may be string, *string, []string, or a nested struct. Keys that already
+// exist in fieldValue (because a previous call to UnpackProperties, such as
+// one applying a `defaults` property struct, already populated this field)
+// have their values merged with the same semantics UnpackProperties uses for
+// the corresponding scalar type: strings and []string entries are appended
+// to, *string entries are replaced, and nested structs are merged
+// field-by-field.
+func unpackMap(m *parser.Map, fieldValue reflect.Value, options *UnpackOptions) error {
+	elemType := fieldValue.Type().Elem()
+
+	if fieldValue.IsNil() {
+		fieldValue.Set(reflect.MakeMapWithSize(fieldValue.Type(), len(m.Properties)))
+	}
+
+	for _, property := range m.Properties {
+		key := reflect.ValueOf(property.Name)
+		existing := fieldValue.MapIndex(key)
+
+		elem := reflect.New(elemType).Elem()
+		if existing.IsValid() {
+			elem.Set(existing)
+		}
+
+		if err := unpackValue(property.Value, elem, nil, options); err != nil {
+			// Preserve unpackValue's *UnpackError/unpackErrors typing rather
+			// than burying it in a new plain error: unpackStruct uses that
+			// typing to tell "this property was consumed, but unpacking
+			// into it failed" apart from "this property never matched a
+			// field", and a plain error here would make a strict-mode error
+			// from a nested struct get double-reported as an unrecognized
+			// property.
+			switch err.(type) {
+			case unpackErrors, *UnpackError:
+				return err
+			default:
+				return fmt.Errorf("can't unpack map key %q: %s", property.Name, err)
+			}
+		}
+
+		fieldValue.SetMapIndex(key, elem)
+	}
+
+	return nil
+}
@@ -0,0 +1,417 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// MarshalJSON converts a property struct, as produced by UnpackProperties or
+// CloneEmptyProperties, into the JSON object an external tool (an IDE
+// plugin, a dashboard, a config generator) would expect to see for it. It
+// honors the same `blueprint:"mutated"` and `blueprint:"filter(...)"` tags
+// and pointer-vs-value conventions that UnpackProperties enforces, and
+// flattens embedded struct and interface fields into their containing
+// object rather than nesting them.
+func MarshalJSON(obj interface{}) ([]byte, error) {
+	tree, err := structToTree(reflect.ValueOf(obj))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+// UnmarshalJSON fills a property struct from JSON previously produced by
+// MarshalJSON (or any JSON object using the same shape). obj must be a
+// pointer to a struct.
+func UnmarshalJSON(data []byte, obj interface{}) error {
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	return treeToStruct(tree, dereference(reflect.ValueOf(obj)))
+}
+
+// MarshalYAML is the YAML equivalent of MarshalJSON.
+func MarshalYAML(obj interface{}) ([]byte, error) {
+	tree, err := structToTree(reflect.ValueOf(obj))
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(tree)
+}
+
+// UnmarshalYAML is the YAML equivalent of UnmarshalJSON.
+func UnmarshalYAML(data []byte, obj interface{}) error {
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	return treeToStruct(tree, dereference(reflect.ValueOf(obj)))
+}
+
+func dereference(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	return v
+}
+
+// structToTree walks structValue the same way UnpackProperties does and
+// produces a map[string]interface{} suitable for json.Marshal or
+// yaml.Marshal: exported, non-mutated fields become entries keyed by
+// PropertyNameForField, nil pointers and nil interfaces are omitted
+// entirely (mirroring an unset `.bp` property), and anonymous fields are
+// flattened into the same map as their containing struct.
+func structToTree(structValue reflect.Value) (map[string]interface{}, error) {
+	return filteredStructToTree(structValue, nil)
+}
+
+// filteredStructToTree is structToTree, restricted to the fields filter
+// allows through; filter comes from a `blueprint:"filter(...)"` tag on the
+// field structValue was reached through, the same as UnpackProperties'
+// nestedFilter.
+func filteredStructToTree(structValue reflect.Value, filter fieldFilter) (map[string]interface{}, error) {
+	structValue = dereference(structValue)
+	if structValue.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("proptools.structToTree: %s is not a struct", structValue.Type())
+	}
+
+	tree := map[string]interface{}{}
+	structType := structValue.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+
+		if field.PkgPath != "" || HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		if filter != nil && !filter(field) {
+			continue
+		}
+
+		nestedFilter, err := filterForField(field)
+		if err != nil {
+			return nil, err
+		}
+
+		if field.Anonymous {
+			nested, err := fieldToTree(fieldValue, nestedFilter)
+			if err != nil {
+				return nil, err
+			}
+			if m, ok := nested.(map[string]interface{}); ok {
+				for k, v := range m {
+					tree[k] = v
+				}
+			}
+			continue
+		}
+
+		value, err := fieldToTree(fieldValue, nestedFilter)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		tree[PropertyNameForField(field.Name)] = value
+	}
+
+	return tree, nil
+}
+
+// fieldToTree converts a single field's value into a JSON/YAML-friendly
+// representation, returning nil for an unset pointer or interface. filter
+// restricts which fields of a struct value (reached directly, or through
+// any number of pointer/interface indirections) are included.
+func fieldToTree(fieldValue reflect.Value, filter fieldFilter) (interface{}, error) {
+	switch fieldValue.Kind() {
+	case reflect.String:
+		return fieldValue.String(), nil
+	case reflect.Bool:
+		return fieldValue.Bool(), nil
+	case reflect.Ptr:
+		if fieldValue.IsNil() {
+			return nil, nil
+		}
+		return fieldToTree(fieldValue.Elem(), filter)
+	case reflect.Interface:
+		if fieldValue.IsNil() {
+			return nil, nil
+		}
+		return fieldToTree(fieldValue.Elem(), filter)
+	case reflect.Slice:
+		if fieldValue.IsNil() {
+			return nil, nil
+		}
+		list := make([]interface{}, fieldValue.Len())
+		for i := range list {
+			v, err := fieldToTree(fieldValue.Index(i), nil)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	case reflect.Map:
+		if fieldValue.IsNil() {
+			return nil, nil
+		}
+		m := map[string]interface{}{}
+		for _, key := range fieldValue.MapKeys() {
+			v, err := fieldToTree(fieldValue.MapIndex(key), nil)
+			if err != nil {
+				return nil, err
+			}
+			m[key.String()] = v
+		}
+		return m, nil
+	case reflect.Struct:
+		return filteredStructToTree(fieldValue, filter)
+	default:
+		return nil, fmt.Errorf("proptools.fieldToTree: unsupported property type %s", fieldValue.Type())
+	}
+}
+
+// treeToStruct is the inverse of structToTree: it fills structValue's
+// exported, non-mutated fields from tree, a map[string]interface{} decoded
+// by encoding/json or gopkg.in/yaml.v2. Anonymous fields are filled from the
+// same tree as their containing struct, mirroring the flattening
+// structToTree performs.
+func treeToStruct(tree map[string]interface{}, structValue reflect.Value) error {
+	return filteredTreeToStruct(tree, structValue, nil)
+}
+
+// filteredTreeToStruct is treeToStruct, restricted to the fields filter
+// allows through; see filteredStructToTree.
+func filteredTreeToStruct(tree map[string]interface{}, structValue reflect.Value, filter fieldFilter) error {
+	structType := structValue.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+
+		if field.PkgPath != "" || HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		if filter != nil && !filter(field) {
+			continue
+		}
+
+		nestedFilter, err := filterForField(field)
+		if err != nil {
+			return err
+		}
+
+		if field.Anonymous {
+			if err := treeToField(tree, fieldValue, nestedFilter); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := tree[PropertyNameForField(field.Name)]
+		if !ok || value == nil {
+			continue
+		}
+		if err := valueToField(value, fieldValue, nestedFilter); err != nil {
+			return fmt.Errorf("field %s: %s", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func treeToField(tree map[string]interface{}, fieldValue reflect.Value, filter fieldFilter) error {
+	if fieldValue.Kind() == reflect.Interface {
+		if fieldValue.IsNil() {
+			newValue, err := newValueForInterfaceTree(tree)
+			if err != nil {
+				return err
+			}
+			fieldValue.Set(newValue)
+			return filteredTreeToStruct(tree, dereference(newValue), filter)
+		}
+		return filteredTreeToStruct(tree, dereference(fieldValue.Elem()), filter)
+	}
+	return filteredTreeToStruct(tree, fieldValue, filter)
+}
+
+func valueToField(value interface{}, fieldValue reflect.Value, filter fieldFilter) error {
+	switch fieldValue.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got %T", value)
+		}
+		fieldValue.SetString(s)
+
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected bool, got %T", value)
+		}
+		fieldValue.SetBool(b)
+
+	case reflect.Ptr:
+		switch fieldValue.Type().Elem().Kind() {
+		case reflect.String:
+			s, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("expected string, got %T", value)
+			}
+			fieldValue.Set(reflect.ValueOf(StringPtr(s)))
+		case reflect.Bool:
+			b, ok := value.(bool)
+			if !ok {
+				return fmt.Errorf("expected bool, got %T", value)
+			}
+			fieldValue.Set(reflect.ValueOf(BoolPtr(b)))
+		case reflect.Struct:
+			m, err := asTree(value)
+			if err != nil {
+				return err
+			}
+			fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+			return filteredTreeToStruct(m, fieldValue.Elem(), filter)
+		default:
+			return fmt.Errorf("unsupported pointer property type %s", fieldValue.Type())
+		}
+
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected list, got %T", value)
+		}
+		result := reflect.MakeSlice(fieldValue.Type(), len(list), len(list))
+		for i, v := range list {
+			if err := valueToField(v, result.Index(i), nil); err != nil {
+				return err
+			}
+		}
+		fieldValue.Set(result)
+
+	case reflect.Map:
+		m, err := asTree(value)
+		if err != nil {
+			return err
+		}
+		result := reflect.MakeMapWithSize(fieldValue.Type(), len(m))
+		for k, v := range m {
+			elem := reflect.New(fieldValue.Type().Elem()).Elem()
+			if err := valueToField(v, elem, nil); err != nil {
+				return err
+			}
+			result.SetMapIndex(reflect.ValueOf(k), elem)
+		}
+		fieldValue.Set(result)
+
+	case reflect.Struct:
+		m, err := asTree(value)
+		if err != nil {
+			return err
+		}
+		return filteredTreeToStruct(m, fieldValue, filter)
+
+	default:
+		return fmt.Errorf("unsupported property type %s", fieldValue.Type())
+	}
+
+	return nil
+}
+
+// asTree normalizes a decoded nested value into map[string]interface{}.
+// encoding/json always decodes JSON objects that way; gopkg.in/yaml.v2
+// decodes YAML mappings as map[interface{}]interface{}, so that shape is
+// converted here too.
+func asTree(value interface{}) (map[string]interface{}, error) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string map key, got %T", k)
+			}
+			result[s] = v
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("expected map, got %T", value)
+	}
+}
+
+// newValueForInterfaceTree builds a new addressable *struct value whose
+// fields mirror the keys of tree, for use as the concrete value stored in an
+// interface{} property field that had no existing concrete type to decode
+// into. It is the JSON/YAML-tree equivalent of UnpackProperties'
+// newValueForInterfaceField: since a decoded tree, unlike a parsed `.bp`
+// file, carries no source order, fields are taken in sorted key order to
+// keep the synthesized type deterministic.
+func newValueForInterfaceTree(tree map[string]interface{}) (reflect.Value, error) {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []reflect.StructField
+	for _, name := range names {
+		fieldType, err := typeForTreeValue(tree[name])
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		fields = append(fields, reflect.StructField{
+			Name: FieldNameForProperty(name),
+			Type: fieldType,
+		})
+	}
+
+	return reflect.New(reflect.StructOf(fields)), nil
+}
+
+func typeForTreeValue(value interface{}) (reflect.Type, error) {
+	switch v := value.(type) {
+	case string:
+		return reflect.TypeOf(""), nil
+	case bool:
+		return reflect.TypeOf(false), nil
+	case []interface{}:
+		return reflect.TypeOf([]string{}), nil
+	case map[string]interface{}, map[interface{}]interface{}:
+		m, err := asTree(v)
+		if err != nil {
+			return nil, err
+		}
+		newValue, err := newValueForInterfaceTree(m)
+		if err != nil {
+			return nil, err
+		}
+		return newValue.Type().Elem(), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T for dynamic property struct", value)
+	}
+}
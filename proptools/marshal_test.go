@@ -0,0 +1,153 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint/parser"
+)
+
+type marshalTestProperties struct {
+	Name    string
+	Vendor  *string
+	Enabled *bool
+	Srcs    []string
+	Arch    map[string]struct {
+		Cflags []string
+	}
+	Nested struct {
+		Foo string
+	}
+	Filtered struct {
+		Foo string `allowMarshal:"true"`
+		Bar string
+	} `blueprint:"filter(allowMarshal:\"true\")"`
+	Mutated string `blueprint:"mutated"`
+	EmbeddedStruct
+	Ext struct {
+		EmbeddedInterface
+	}
+}
+
+const marshalTestInput = `
+	m {
+		name: "libfoo",
+		vendor: "acme",
+		enabled: true,
+		srcs: ["a.c", "b.c"],
+		s: "embedded",
+		arch: {
+			arm: {
+				cflags: ["-DARM"],
+			},
+		},
+		nested: {
+			foo: "bar",
+		},
+		filtered: {
+			foo: "abc",
+			bar: "ignored",
+		},
+		ext: {
+			flag: true,
+		},
+	}
+`
+
+// unpackMarshalTestModule parses marshalTestInput and unpacks it into a
+// fresh marshalTestProperties, then simulates the parts of a real build's
+// pipeline that MarshalJSON/MarshalYAML must not carry across a round trip:
+// a mutator setting a `blueprint:"mutated"` field after parsing, and code
+// writing directly into a field a `blueprint:"filter(...)"` tag excludes
+// from `.bp` syntax.
+func unpackMarshalTestModule(t *testing.T) *marshalTestProperties {
+	t.Helper()
+
+	r := bytes.NewBufferString(marshalTestInput)
+	file, errs := parser.ParseAndEval("", r, parser.NewScope(nil))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	var props *marshalTestProperties
+	for _, def := range file.Defs {
+		module, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+		props = &marshalTestProperties{}
+		if _, errs := UnpackProperties(module.Properties, props); len(errs) != 0 {
+			t.Fatalf("unexpected unpack errors: %v", errs)
+		}
+	}
+	if props == nil {
+		t.Fatal("test input contained no module")
+	}
+
+	props.Mutated = "set-by-a-mutator"
+	props.Filtered.Bar = "set-directly, not through filtered bp syntax"
+
+	return props
+}
+
+// marshalRoundTripWant returns the result the round trip through
+// MarshalJSON/MarshalYAML is expected to produce: props with the fields
+// that must not survive the round trip (mutated, and filtered-out fields)
+// zeroed back out.
+func marshalRoundTripWant(props *marshalTestProperties) *marshalTestProperties {
+	want := *props
+	want.Mutated = ""
+	want.Filtered.Bar = ""
+	return &want
+}
+
+func TestMarshalJSONRoundTrip(t *testing.T) {
+	props := unpackMarshalTestModule(t)
+
+	data, err := MarshalJSON(props)
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %s", err)
+	}
+
+	got := &marshalTestProperties{}
+	if err := UnmarshalJSON(data, got); err != nil {
+		t.Fatalf("UnmarshalJSON failed: %s", err)
+	}
+
+	if want := marshalRoundTripWant(props); !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip mismatch:\n original: %+v\n want:     %+v\n got:      %+v", props, want, got)
+	}
+}
+
+func TestMarshalYAMLRoundTrip(t *testing.T) {
+	props := unpackMarshalTestModule(t)
+
+	data, err := MarshalYAML(props)
+	if err != nil {
+		t.Fatalf("MarshalYAML failed: %s", err)
+	}
+
+	got := &marshalTestProperties{}
+	if err := UnmarshalYAML(data, got); err != nil {
+		t.Fatalf("UnmarshalYAML failed: %s", err)
+	}
+
+	if want := marshalRoundTripWant(props); !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip mismatch:\n original: %+v\n want:     %+v\n got:      %+v", props, want, got)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package proptools provides helpers to convert between Blueprint's
+// property-struct representation and the parsed `.bp` syntax tree.
+package proptools
+
+import "unicode"
+import "unicode/utf8"
+
+// StringPtr returns a pointer to a new string variable containing s.
+func StringPtr(s string) *string {
+	return &s
+}
+
+// BoolPtr returns a pointer to a new bool variable containing b.
+func BoolPtr(b bool) *bool {
+	return &b
+}
+
+// Int64Ptr returns a pointer to a new int64 variable containing i.
+func Int64Ptr(i int64) *int64 {
+	return &i
+}
+
+// String returns the value of a string pointer, or the empty string if the
+// pointer is nil.
+func String(s *string) string {
+	if s != nil {
+		return *s
+	}
+	return ""
+}
+
+// Bool returns the value of a bool pointer, or false if the pointer is nil.
+func Bool(b *bool) bool {
+	if b != nil {
+		return *b
+	}
+	return false
+}
+
+// BoolDefault returns the value of a bool pointer, or def if the pointer is
+// nil.
+func BoolDefault(b *bool, def bool) bool {
+	if b != nil {
+		return *b
+	}
+	return def
+}
+
+// Int64 returns the value of an int64 pointer, or 0 if the pointer is nil.
+func Int64(i *int64) int64 {
+	if i != nil {
+		return *i
+	}
+	return 0
+}
+
+// PropertyNameForField converts an exported Go struct field name into the
+// property name used to address it from a `.bp` file by lower-casing the
+// first rune. "Foo_bar" becomes "foo_bar", "S" becomes "s".
+func PropertyNameForField(fieldName string) string {
+	r, n := utf8.DecodeRuneInString(fieldName)
+	if r == utf8.RuneError {
+		return fieldName
+	}
+	return string(unicode.ToLower(r)) + fieldName[n:]
+}
+
+// FieldNameForProperty converts a property name as it appears in a `.bp`
+// file into the corresponding exported Go struct field name by
+// upper-casing the first rune. It is the inverse of PropertyNameForField.
+func FieldNameForProperty(property string) string {
+	r, n := utf8.DecodeRuneInString(property)
+	if r == utf8.RuneError {
+		return property
+	}
+	return string(unicode.ToUpper(r)) + property[n:]
+}
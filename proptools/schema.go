@@ -0,0 +1,180 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// SchemaType identifies the shape a Schema node describes.
+type SchemaType string
+
+const (
+	SchemaString SchemaType = "string"
+	SchemaBool   SchemaType = "bool"
+	SchemaList   SchemaType = "list"
+	SchemaMap    SchemaType = "map"
+	SchemaStruct SchemaType = "struct"
+	SchemaAny    SchemaType = "any"
+)
+
+// Schema is a machine-readable description of a property struct, built by
+// walking the same reflection tree UnpackProperties does. It is meant for
+// consumers that want to validate or document `.bp` fragments (a language
+// server, a doc generator, a config validator) without invoking the Go
+// unpacker.
+type Schema struct {
+	// Type is the kind of value this property accepts.
+	Type SchemaType
+
+	// Optional is true for fields declared as a pointer, meaning the
+	// property may be omitted rather than defaulting to the zero value.
+	Optional bool
+
+	// Filter holds the `blueprint:"filter(key:\"value\")"` tag restricting
+	// which of Fields may be set, if this field carries one.
+	Filter *SchemaFilter
+
+	// Elem describes the element type of a SchemaList or the value type of
+	// a SchemaMap.
+	Elem *Schema
+
+	// Fields describes the named properties of a SchemaStruct, keyed by
+	// property name (as produced by PropertyNameForField).
+	Fields map[string]*Schema
+}
+
+// SchemaFilter is the parsed form of a `blueprint:"filter(key:\"value\")"`
+// tag: only nested fields carrying a matching struct tag may be set.
+type SchemaFilter struct {
+	Key   string
+	Value string
+}
+
+// PropertySchema walks structPtr, a pointer to a property struct of the kind
+// produced by UnpackProperties or CloneEmptyProperties, and returns a
+// Schema describing every field: its type, whether it is optional, its
+// tag-driven filter, and its nested or embedded structure. Anonymous
+// (embedded) struct fields are flattened into their containing struct's
+// Fields, mirroring how UnpackProperties addresses them. The schema is built
+// from the static type alone, so an anonymous interface field contributes
+// no entries to Fields.
+func PropertySchema(structPtr interface{}) Schema {
+	v := reflect.ValueOf(structPtr)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Errorf("proptools.PropertySchema: %s is not a pointer to a struct", v.Type()))
+	}
+	return schemaForStruct(v.Elem().Type())
+}
+
+func schemaForStruct(structType reflect.Type) Schema {
+	schema := Schema{Type: SchemaStruct, Fields: map[string]*Schema{}}
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" || HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		if field.Anonymous {
+			embedded := schemaForField(field)
+			for name, fieldSchema := range embedded.Fields {
+				schema.Fields[name] = fieldSchema
+			}
+			continue
+		}
+
+		fieldSchema := schemaForField(field)
+		schema.Fields[PropertyNameForField(field.Name)] = &fieldSchema
+	}
+
+	return schema
+}
+
+func schemaForField(field reflect.StructField) Schema {
+	s := schemaForType(field.Type)
+
+	if s.Type == SchemaStruct {
+		if tag, ok := blueprintFilterTag(field); ok {
+			s.Filter = &tag
+		}
+	}
+
+	return s
+}
+
+// blueprintFilterTag re-parses the `blueprint:"filter(key:\"value\")"` tag
+// into its key/value for reporting in a Schema, rather than the predicate
+// function filterForField builds for unpacking.
+func blueprintFilterTag(field reflect.StructField) (SchemaFilter, bool) {
+	tag := field.Tag.Get("blueprint")
+	const prefix = "filter("
+	const suffix = ")"
+	for _, entry := range splitTag(tag) {
+		if len(entry) <= len(prefix)+len(suffix) || entry[:len(prefix)] != prefix || entry[len(entry)-len(suffix):] != suffix {
+			continue
+		}
+		inner := entry[len(prefix) : len(entry)-len(suffix)]
+		for i := 0; i < len(inner); i++ {
+			if inner[i] == ':' {
+				value, err := parseQuoted(inner[i+1:])
+				if err != nil {
+					return SchemaFilter{}, false
+				}
+				return SchemaFilter{Key: inner[:i], Value: value}, true
+			}
+		}
+	}
+	return SchemaFilter{}, false
+}
+
+func splitTag(tag string) []string {
+	var result []string
+	start := 0
+	for i := 0; i < len(tag); i++ {
+		if tag[i] == ',' {
+			result = append(result, tag[start:i])
+			start = i + 1
+		}
+	}
+	result = append(result, tag[start:])
+	return result
+}
+
+func schemaForType(t reflect.Type) Schema {
+	switch t.Kind() {
+	case reflect.String:
+		return Schema{Type: SchemaString}
+	case reflect.Bool:
+		return Schema{Type: SchemaBool}
+	case reflect.Ptr:
+		s := schemaForType(t.Elem())
+		s.Optional = true
+		return s
+	case reflect.Slice:
+		elem := schemaForType(t.Elem())
+		return Schema{Type: SchemaList, Elem: &elem}
+	case reflect.Map:
+		elem := schemaForType(t.Elem())
+		return Schema{Type: SchemaMap, Elem: &elem}
+	case reflect.Struct:
+		return schemaForStruct(t)
+	case reflect.Interface:
+		return Schema{Type: SchemaAny, Optional: true}
+	default:
+		panic(fmt.Errorf("proptools.PropertySchema: unsupported property type %s", t))
+	}
+}
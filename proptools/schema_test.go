@@ -0,0 +1,130 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func str(s SchemaType) *Schema { return &Schema{Type: s} }
+
+// golden maps the index of an entry in validUnpackTestCases to the expected
+// Schema for its first output property struct.
+var schemaGoldenTestCases = map[int]Schema{
+	// s/blank/unset *string fields.
+	0: {
+		Type: SchemaStruct,
+		Fields: map[string]*Schema{
+			"s":     {Type: SchemaString, Optional: true},
+			"blank": {Type: SchemaString, Optional: true},
+			"unset": {Type: SchemaString, Optional: true},
+		},
+	},
+	// isGood/isBad/isUgly *bool fields.
+	3: {
+		Type: SchemaStruct,
+		Fields: map[string]*Schema{
+			"isGood": {Type: SchemaBool, Optional: true},
+			"isBad":  {Type: SchemaBool, Optional: true},
+			"isUgly": {Type: SchemaBool, Optional: true},
+		},
+	},
+	// stuff/empty/nil []string fields, NonString is mutated and dropped.
+	4: {
+		Type: SchemaStruct,
+		Fields: map[string]*Schema{
+			"stuff": {Type: SchemaList, Elem: str(SchemaString)},
+			"empty": {Type: SchemaList, Elem: str(SchemaString)},
+			"nil":   {Type: SchemaList, Elem: str(SchemaString)},
+		},
+	},
+	// nested struct carrying a filter(allowNested:"true") tag.
+	8: {
+		Type: SchemaStruct,
+		Fields: map[string]*Schema{
+			"nested": {
+				Type:   SchemaStruct,
+				Filter: &SchemaFilter{Key: "allowNested", Value: "true"},
+				Fields: map[string]*Schema{
+					"foo": {Type: SchemaString},
+				},
+			},
+			"bar": {Type: SchemaBool},
+			"baz": {Type: SchemaList, Elem: str(SchemaString)},
+		},
+	},
+	// flat map[string]string.
+	18: {
+		Type: SchemaStruct,
+		Fields: map[string]*Schema{
+			"dict": {Type: SchemaMap, Elem: str(SchemaString)},
+		},
+	},
+	// map[string]T with a nested struct value.
+	21: {
+		Type: SchemaStruct,
+		Fields: map[string]*Schema{
+			"dict": {
+				Type: SchemaMap,
+				Elem: &Schema{
+					Type: SchemaStruct,
+					Fields: map[string]*Schema{
+						"cflags": {Type: SchemaList, Elem: str(SchemaString)},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestPropertySchemaGolden(t *testing.T) {
+	for i, want := range schemaGoldenTestCases {
+		testCase := validUnpackTestCases[i]
+		got := PropertySchema(testCase.output[0])
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("test case %d: incorrect schema:\n  expected: %+v\n       got: %+v", i, want, got)
+		}
+	}
+}
+
+// TestPropertySchemaAllCases exercises every validUnpackTestCases entry to
+// make sure PropertySchema can walk each shape present in the unpacker's own
+// test suite without panicking, and that it reports at least as many fields
+// as the struct has non-mutated, non-anonymous fields of its own (flattened
+// embedded fields may add more).
+func TestPropertySchemaAllCases(t *testing.T) {
+	for i, testCase := range validUnpackTestCases {
+		for _, obj := range testCase.output {
+			schema := PropertySchema(obj)
+			if schema.Type != SchemaStruct {
+				t.Errorf("test case %d: top-level schema should be a struct, got %s", i, schema.Type)
+			}
+
+			structType := reflect.ValueOf(obj).Elem().Type()
+			wantFields := 0
+			for f := 0; f < structType.NumField(); f++ {
+				field := structType.Field(f)
+				if field.PkgPath != "" || field.Anonymous || HasTag(field, "blueprint", "mutated") {
+					continue
+				}
+				wantFields++
+			}
+			if len(schema.Fields) < wantFields {
+				t.Errorf("test case %d: expected at least %d fields, got %d", i, wantFields, len(schema.Fields))
+			}
+		}
+	}
+}
@@ -0,0 +1,543 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+)
+
+// UnpackError describes a single problem unpacking a property from the
+// parsed `.bp` syntax tree into a Go property struct.
+type UnpackError struct {
+	Err error
+	Pos scanner.Position
+}
+
+func (e *UnpackError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Err)
+}
+
+// unpackErrors carries every error a nested unpackStruct call produced back
+// up to its caller through unpackValue's single-error return, so that the
+// caller can report all of them instead of just the first. Its elements are
+// always already-positioned *UnpackError values.
+type unpackErrors []error
+
+func (e unpackErrors) Error() string {
+	if len(e) == 0 {
+		return ""
+	}
+	return e[0].Error()
+}
+
+// UnpackOptions controls the optional strict/lint behavior of
+// UnpackPropertiesWithOptions.
+type UnpackOptions struct {
+	// Strict turns an input property with no matching destination field,
+	// at any nesting level, into an *UnpackError positioned at the
+	// property's own token rather than being silently ignored.
+	Strict bool
+
+	// Deprecated maps a property name to a message to report through
+	// WarnFunc when that property is set, regardless of whether it still
+	// has a matching destination field. This covers properties that have
+	// been removed outright; a field that still exists but is on its way
+	// out should instead carry a `blueprint:"deprecated:'<message>'"` tag,
+	// which is reported the same way.
+	Deprecated map[string]string
+
+	// WarnFunc, if non-nil, is called once for every deprecated property
+	// that is set, with the position of the property in the source file
+	// and a human-readable message.
+	WarnFunc func(pos scanner.Position, msg string)
+}
+
+// UnpackProperties fills one or more property structs from the given list
+// of parsed properties, usually module.Properties of a *parser.Module. Each
+// element of objects must be a pointer to a struct; unexported fields and
+// fields tagged `blueprint:"mutated"` are left untouched. Properties that do
+// not correspond to an exported field of any of the objects are silently
+// ignored.
+//
+// Unpacking into a property struct that is not freshly zero-valued merges
+// the parsed values onto the existing ones: strings are appended, bools are
+// OR'd, and slices are appended to. This allows the same function to apply
+// a chain of `defaults` property structs on top of each other. String and
+// bool pointer fields are replaced outright, since a nil pointer already
+// represents "not set"; a non-nil pointer to a struct has the parsed values
+// merged into the struct it points to.
+//
+// It returns the list of property names that were consumed, and the list of
+// errors encountered, which are all of type *UnpackError.
+func UnpackProperties(properties []*parser.Property, objects ...interface{}) ([]string, []error) {
+	return UnpackPropertiesWithOptions(nil, properties, objects...)
+}
+
+// UnpackPropertiesWithOptions is UnpackProperties with optional strict-mode
+// and deprecated-property reporting; see UnpackOptions. Passing nil options
+// is equivalent to calling UnpackProperties.
+func UnpackPropertiesWithOptions(options *UnpackOptions, properties []*parser.Property, objects ...interface{}) ([]string, []error) {
+	var unpackedPropertyNames []string
+	var errs []error
+
+	for _, obj := range objects {
+		v := reflect.ValueOf(obj)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			panic(fmt.Errorf("proptools.UnpackProperties: %s is not a pointer to a struct", v.Type()))
+		}
+
+		// The top-level properties list is shared across every object
+		// passed to this call, so an individual object's unpackStruct
+		// invocation can't yet tell whether a property it didn't consume
+		// is genuinely unknown or just meant for one of the other objects.
+		// Pass topLevel so it defers that check to us, below, once every
+		// object has had a turn.
+		used, propErrs := unpackStruct(properties, v.Elem(), nil, options, true)
+		unpackedPropertyNames = append(unpackedPropertyNames, used...)
+		errs = append(errs, propErrs...)
+	}
+
+	errs = append(errs, checkUnknownProperties(properties, unpackedPropertyNames, options)...)
+
+	return unpackedPropertyNames, errs
+}
+
+// checkUnknownProperties reports every property that no field consumed: one
+// named in options.Deprecated through WarnFunc, and any other, in strict
+// mode only, as an error. At the top level this check is deferred; see the
+// comment in UnpackPropertiesWithOptions.
+func checkUnknownProperties(properties []*parser.Property, used []string, options *UnpackOptions) []error {
+	if options == nil {
+		return nil
+	}
+
+	usedSet := make(map[string]bool, len(used))
+	for _, name := range used {
+		usedSet[name] = true
+	}
+
+	var errs []error
+	for _, property := range properties {
+		if usedSet[property.Name] {
+			continue
+		}
+		if msg, deprecated := options.Deprecated[property.Name]; deprecated {
+			if options.WarnFunc != nil {
+				options.WarnFunc(property.NamePos, fmt.Sprintf("%s is deprecated: %s", property.Name, msg))
+			}
+			continue
+		}
+		if !options.Strict {
+			continue
+		}
+		errs = append(errs, &UnpackError{
+			fmt.Errorf("unrecognized property %q", property.Name),
+			property.NamePos,
+		})
+	}
+	return errs
+}
+
+// unpackStruct unpacks the properties addressed by structValue's exported,
+// non-mutated fields, including those reached through anonymous (embedded)
+// struct and interface fields. filter, if non-nil, is consulted for every
+// field of structValue and skips any field it rejects; it is how a
+// `blueprint:"filter(...)"` tag on an enclosing field restricts which of a
+// nested struct's fields may be set. It returns the names of the top-level
+// properties it consumed.
+//
+// topLevel should be true only for the direct calls UnpackPropertiesWithOptions
+// makes for each object it was given: since those all share one property
+// list, whether a property any one of them left unused is actually unknown
+// can't be decided until every object has had a turn, so that check is
+// deferred to checkUnknownProperties instead of being made here.
+func unpackStruct(properties []*parser.Property, structValue reflect.Value, filter fieldFilter, options *UnpackOptions, topLevel bool) ([]string, []error) {
+	var used []string
+	var errs []error
+
+	structType := structValue.Type()
+
+	// A named (non-anonymous) field of struct type explicitly owns the
+	// nested-map property with its name; an anonymous interface{} field at
+	// the same level must not also try to synthesize a type for that
+	// property, or it would end up with a different shape than the named
+	// field that already claimed it. Scalar-valued properties have no such
+	// exclusivity: both a named field and a sibling embedded interface may
+	// independently be set from the same property.
+	structTypedNames := map[string]bool{}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" || field.Anonymous {
+			continue
+		}
+		if underlyingKind(field.Type) == reflect.Struct {
+			structTypedNames[PropertyNameForField(field.Name)] = true
+		}
+	}
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+
+		if field.PkgPath != "" {
+			// Unexported field.
+			continue
+		}
+
+		if HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		if filter != nil && !filter(field) {
+			continue
+		}
+
+		if field.Anonymous {
+			embeddedUsed, embeddedErrs := unpackEmbedded(properties, fieldValue, filter, structTypedNames, options)
+			used = append(used, embeddedUsed...)
+			errs = append(errs, embeddedErrs...)
+			continue
+		}
+
+		propertyName := PropertyNameForField(field.Name)
+		property := lookupProperty(properties, propertyName)
+		if property == nil {
+			continue
+		}
+
+		warnIfDeprecated(field, property, options)
+
+		nestedFilter, err := filterForField(field)
+		if err != nil {
+			errs = append(errs, &UnpackError{err, property.NamePos})
+			continue
+		}
+
+		if err := unpackValue(property.Value, fieldValue, nestedFilter, options); err != nil {
+			// unpackValue may have already produced one or more *UnpackError
+			// values of its own, positioned at the specific nested
+			// property(ies) that caused them (e.g. unrecognized keys several
+			// levels down); don't clobber those with this property's own
+			// position, and don't drop any but the first. In that case the
+			// property was still genuinely consumed by this field - the
+			// failure happened while unpacking *into* it, not because it
+			// didn't belong here - so it must count as used or the deferred
+			// strict-mode check would report it a second time as its own
+			// unrecognized property.
+			switch typedErr := err.(type) {
+			case unpackErrors:
+				errs = append(errs, typedErr...)
+				used = append(used, propertyName)
+			case *UnpackError:
+				errs = append(errs, typedErr)
+				used = append(used, propertyName)
+			default:
+				errs = append(errs, &UnpackError{err, property.Value.Pos()})
+			}
+			continue
+		}
+
+		used = append(used, propertyName)
+	}
+
+	if !topLevel {
+		errs = append(errs, checkUnknownProperties(properties, used, options)...)
+	}
+
+	return used, errs
+}
+
+// warnIfDeprecated reports property through options.WarnFunc if it is
+// either tagged `blueprint:"deprecated:'<message>'"` on field, or named in
+// options.Deprecated.
+func warnIfDeprecated(field reflect.StructField, property *parser.Property, options *UnpackOptions) {
+	if options == nil || options.WarnFunc == nil {
+		return
+	}
+
+	if msg, ok := deprecationMessage(field); ok {
+		options.WarnFunc(property.NamePos, fmt.Sprintf("%s is deprecated: %s", property.Name, msg))
+		return
+	}
+
+	if msg, ok := options.Deprecated[property.Name]; ok {
+		options.WarnFunc(property.NamePos, fmt.Sprintf("%s is deprecated: %s", property.Name, msg))
+	}
+}
+
+// deprecationMessage extracts the message out of a
+// `blueprint:"deprecated:'<message>'"` tag, if field carries one. Single
+// quotes are used to delimit the message, rather than the comma-separated
+// tag items the rest of the `blueprint` tag uses, so the message itself may
+// contain commas.
+func deprecationMessage(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("blueprint")
+	const prefix = "deprecated:'"
+
+	start := strings.Index(tag, prefix)
+	if start < 0 {
+		return "", false
+	}
+	rest := tag[start+len(prefix):]
+	end := strings.Index(rest, "'")
+	if end < 0 {
+		return "", false
+	}
+	return rest[:end], true
+}
+
+// unpackEmbedded unpacks into an anonymously embedded struct or interface
+// field, which shares the current property namespace with its containing
+// struct rather than being addressed by its own name. claimed holds the
+// names of sibling struct-typed named fields, whose nested-map properties
+// an embedded interface{} must not also try to capture dynamically.
+//
+// The recursive unpackStruct calls below always pass topLevel true: this
+// embedded field shares its caller's property list, and it's the caller's
+// loop, not this call, that knows the full set of names every field at this
+// level (named and embedded alike) consumed, so running the unknown-property
+// check here would flag names a sibling field was about to claim. It's the
+// caller's job to run that check once its loop finishes.
+func unpackEmbedded(properties []*parser.Property, fieldValue reflect.Value, filter fieldFilter, claimed map[string]bool, options *UnpackOptions) ([]string, []error) {
+	if fieldValue.Kind() == reflect.Interface {
+		if fieldValue.IsNil() {
+			var unclaimed []*parser.Property
+			for _, property := range properties {
+				if !claimed[property.Name] {
+					unclaimed = append(unclaimed, property)
+				}
+			}
+
+			newValue, err := newValueForInterfaceField(&parser.Map{Properties: unclaimed})
+			if err != nil {
+				return nil, []error{&UnpackError{err, scanner.Position{}}}
+			}
+			fieldValue.Set(newValue)
+			return unpackStruct(unclaimed, indirect(fieldValue.Elem()), filter, options, true)
+		}
+		return unpackStruct(properties, indirect(fieldValue.Elem()), filter, options, true)
+	}
+
+	return unpackStruct(properties, fieldValue, filter, options, true)
+}
+
+// underlyingKind returns the reflect.Kind of t after following any pointer
+// indirection.
+func underlyingKind(t reflect.Type) reflect.Kind {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind()
+}
+
+func lookupProperty(properties []*parser.Property, name string) *parser.Property {
+	for _, property := range properties {
+		if property.Name == name {
+			return property
+		}
+	}
+	return nil
+}
+
+// indirect follows ptrValue until it reaches a non-pointer value.
+func indirect(ptrValue reflect.Value) reflect.Value {
+	for ptrValue.Kind() == reflect.Ptr {
+		ptrValue = ptrValue.Elem()
+	}
+	return ptrValue
+}
+
+// unpackValue assigns the value of a single parsed expression into
+// fieldValue, which must be addressable. It merges onto any existing
+// (non-pointer) contents of fieldValue rather than replacing them outright.
+// filter restricts which fields of a nested struct value may be set, as
+// determined by a `blueprint:"filter(...)"` tag on the field this value is
+// destined for.
+func unpackValue(expr parser.Expression, fieldValue reflect.Value, filter fieldFilter, options *UnpackOptions) error {
+	switch fieldValue.Kind() {
+	case reflect.Ptr:
+		switch fieldValue.Type().Elem().Kind() {
+		case reflect.String:
+			s, ok := expr.(*parser.String)
+			if !ok {
+				return fmt.Errorf("can't assign %s value to string property", expr.Type())
+			}
+			fieldValue.Set(reflect.ValueOf(StringPtr(s.Value)))
+		case reflect.Bool:
+			b, ok := expr.(*parser.Bool)
+			if !ok {
+				return fmt.Errorf("can't assign %s value to bool property", expr.Type())
+			}
+			fieldValue.Set(reflect.ValueOf(BoolPtr(b.Value)))
+		case reflect.Struct:
+			m, ok := expr.(*parser.Map)
+			if !ok {
+				return fmt.Errorf("can't assign %s value to struct property", expr.Type())
+			}
+			if fieldValue.IsNil() {
+				fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+			}
+			if _, errs := unpackStruct(m.Properties, fieldValue.Elem(), filter, options, false); len(errs) > 0 {
+				return unpackErrors(errs)
+			}
+		default:
+			return fmt.Errorf("unsupported pointer property type %s", fieldValue.Type())
+		}
+
+	case reflect.String:
+		s, ok := expr.(*parser.String)
+		if !ok {
+			return fmt.Errorf("can't assign %s value to string property", expr.Type())
+		}
+		fieldValue.SetString(fieldValue.String() + s.Value)
+
+	case reflect.Bool:
+		b, ok := expr.(*parser.Bool)
+		if !ok {
+			return fmt.Errorf("can't assign %s value to bool property", expr.Type())
+		}
+		fieldValue.SetBool(fieldValue.Bool() || b.Value)
+
+	case reflect.Slice:
+		list, ok := expr.(*parser.List)
+		if !ok {
+			return fmt.Errorf("can't assign %s value to list property", expr.Type())
+		}
+		result := fieldValue
+		if fieldValue.IsNil() {
+			result = reflect.MakeSlice(fieldValue.Type(), 0, len(list.Values))
+		}
+		for _, v := range list.Values {
+			s, ok := v.(*parser.String)
+			if !ok {
+				return fmt.Errorf("can't assign %s value in list property", v.Type())
+			}
+			result = reflect.Append(result, reflect.ValueOf(s.Value))
+		}
+		fieldValue.Set(result)
+
+	case reflect.Struct:
+		m, ok := expr.(*parser.Map)
+		if !ok {
+			return fmt.Errorf("can't assign %s value to struct property", expr.Type())
+		}
+		if _, errs := unpackStruct(m.Properties, fieldValue, filter, options, false); len(errs) > 0 {
+			return unpackErrors(errs)
+		}
+
+	case reflect.Map:
+		m, ok := expr.(*parser.Map)
+		if !ok {
+			return fmt.Errorf("can't assign %s value to map property", expr.Type())
+		}
+		return unpackMap(m, fieldValue, options)
+
+	case reflect.Interface:
+		if !fieldValue.IsNil() {
+			elem := fieldValue.Elem()
+			if elem.Kind() == reflect.Ptr {
+				// Interface values aren't addressable, and the pointer it
+				// holds may be nil (there was nothing to unpack into the
+				// last time around), so build an addressable copy of the
+				// pointed-to struct, fill that in, and write the pointer
+				// back once it's done.
+				target := reflect.New(elem.Type().Elem())
+				if !elem.IsNil() {
+					target.Elem().Set(elem.Elem())
+				}
+				if err := unpackValue(expr, target.Elem(), filter, options); err != nil {
+					return err
+				}
+				fieldValue.Set(target)
+				return nil
+			}
+			newElem := reflect.New(elem.Type()).Elem()
+			newElem.Set(elem)
+			if err := unpackValue(expr, newElem, filter, options); err != nil {
+				return err
+			}
+			fieldValue.Set(newElem)
+			return nil
+		}
+		m, ok := expr.(*parser.Map)
+		if !ok {
+			return fmt.Errorf("can't assign %s value to untyped interface property", expr.Type())
+		}
+		newValue, err := newValueForInterfaceField(m)
+		if err != nil {
+			return err
+		}
+		fieldValue.Set(newValue)
+		if _, errs := unpackStruct(m.Properties, indirect(newValue), filter, options, false); len(errs) > 0 {
+			return unpackErrors(errs)
+		}
+
+	default:
+		return fmt.Errorf("unsupported property type %s", fieldValue.Type())
+	}
+
+	return nil
+}
+
+// newValueForInterfaceField builds a new addressable *struct value whose
+// fields mirror the keys of m, for use as the concrete value stored in an
+// interface{} property field that had no existing concrete type to unpack
+// into.
+func newValueForInterfaceField(m *parser.Map) (reflect.Value, error) {
+	var fields []reflect.StructField
+	for _, property := range m.Properties {
+		fieldType, err := typeForExpression(property.Value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		fields = append(fields, reflect.StructField{
+			Name: FieldNameForProperty(property.Name),
+			Type: fieldType,
+		})
+	}
+
+	return reflect.New(reflect.StructOf(fields)), nil
+}
+
+func typeForExpression(expr parser.Expression) (reflect.Type, error) {
+	switch t := expr.(type) {
+	case *parser.String:
+		return reflect.TypeOf(""), nil
+	case *parser.Bool:
+		return reflect.TypeOf(false), nil
+	case *parser.List:
+		return reflect.TypeOf([]string{}), nil
+	case *parser.Map:
+		var fields []reflect.StructField
+		for _, property := range t.Properties {
+			fieldType, err := typeForExpression(property.Value)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, reflect.StructField{
+				Name: FieldNameForProperty(property.Name),
+				Type: fieldType,
+			})
+		}
+		return reflect.StructOf(fields), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %s for dynamic property struct", expr.Type())
+	}
+}
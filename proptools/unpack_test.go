@@ -23,6 +23,9 @@ import (
 	"github.com/google/blueprint/parser"
 )
 
+type EmbeddedStruct struct{ S string }
+type EmbeddedInterface interface{}
+
 var validUnpackTestCases = []struct {
 	input  string
 	output []interface{}
@@ -508,6 +511,207 @@ var validUnpackTestCases = []struct {
 			},
 		},
 	},
+
+	// Flat map[string]string
+	{
+		input: `
+			m {
+				dict: {
+					foo: "a",
+					bar: "b",
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Dict map[string]string
+			}{
+				Dict: map[string]string{
+					"foo": "a",
+					"bar": "b",
+				},
+			},
+		},
+	},
+
+	// map[string]*string
+	{
+		input: `
+			m {
+				dict: {
+					foo: "a",
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Dict map[string]*string
+			}{
+				Dict: map[string]*string{
+					"foo": StringPtr("a"),
+				},
+			},
+		},
+	},
+
+	// map[string][]string
+	{
+		input: `
+			m {
+				dict: {
+					foo: ["a", "b"],
+					bar: ["c"],
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Dict map[string][]string
+			}{
+				Dict: map[string][]string{
+					"foo": {"a", "b"},
+					"bar": {"c"},
+				},
+			},
+		},
+	},
+
+	// map[string]T with a nested struct value
+	{
+		input: `
+			m {
+				dict: {
+					arm: {
+						cflags: ["-DARM"],
+					},
+					arm64: {
+						cflags: ["-DARM64"],
+					},
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Dict map[string]struct {
+					Cflags []string
+				}
+			}{
+				Dict: map[string]struct {
+					Cflags []string
+				}{
+					"arm": {
+						Cflags: []string{"-DARM"},
+					},
+					"arm64": {
+						Cflags: []string{"-DARM64"},
+					},
+				},
+			},
+		},
+	},
+
+	// Map merging: unpacking onto an already-populated map merges per key
+	// using the same semantics as the corresponding scalar type, as happens
+	// when a `defaults` property struct is applied on top of another.
+	{
+		input: `
+			m {
+				dict: {
+					foo: "b",
+					baz: "c",
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Dict map[string]string
+			}{
+				Dict: map[string]string{
+					"foo": "ab",
+					"bar": "a",
+					"baz": "c",
+				},
+			},
+		},
+		empty: []interface{}{
+			&struct {
+				Dict map[string]string
+			}{
+				Dict: map[string]string{
+					"foo": "a",
+					"bar": "a",
+				},
+			},
+		},
+	},
+
+	// Map merging: map[string]*string entries are replaced outright, like
+	// any other pointer field.
+	{
+		input: `
+			m {
+				dict: {
+					foo: "b",
+					baz: "c",
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Dict map[string]*string
+			}{
+				Dict: map[string]*string{
+					"foo": StringPtr("b"),
+					"bar": StringPtr("a"),
+					"baz": StringPtr("c"),
+				},
+			},
+		},
+		empty: []interface{}{
+			&struct {
+				Dict map[string]*string
+			}{
+				Dict: map[string]*string{
+					"foo": StringPtr("a"),
+					"bar": StringPtr("a"),
+				},
+			},
+		},
+	},
+
+	// Map merging: map[string][]string entries are appended to, like any
+	// other list field.
+	{
+		input: `
+			m {
+				dict: {
+					foo: ["b"],
+					baz: ["c"],
+				},
+			}
+		`,
+		output: []interface{}{
+			&struct {
+				Dict map[string][]string
+			}{
+				Dict: map[string][]string{
+					"foo": {"a", "b"},
+					"bar": {"a"},
+					"baz": {"c"},
+				},
+			},
+		},
+		empty: []interface{}{
+			&struct {
+				Dict map[string][]string
+			}{
+				Dict: map[string][]string{
+					"foo": {"a"},
+					"bar": {"a"},
+				},
+			},
+		},
+	},
 }
 
 func TestUnpackProperties(t *testing.T) {
@@ -577,3 +781,227 @@ func mkpos(offset, line, column int) scanner.Position {
 		Column: column,
 	}
 }
+
+func parseModuleProperties(t *testing.T, input string) []*parser.Property {
+	t.Helper()
+
+	r := bytes.NewBufferString(input)
+	file, errs := parser.ParseAndEval("", r, parser.NewScope(nil))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	for _, def := range file.Defs {
+		if module, ok := def.(*parser.Module); ok {
+			return module.Properties
+		}
+	}
+
+	t.Fatal("test input contained no module")
+	return nil
+}
+
+func TestUnpackPropertiesStrict(t *testing.T) {
+	properties := parseModuleProperties(t, `
+		m {
+			s: "abc",
+			typo: "oops",
+		}
+	`)
+
+	dst := &struct {
+		S string
+	}{}
+
+	_, errs := UnpackPropertiesWithOptions(&UnpackOptions{Strict: true}, properties, dst)
+	if len(errs) != 1 {
+		t.Fatalf("expected exactly one error, got %v", errs)
+	}
+
+	unpackErr, ok := errs[0].(*UnpackError)
+	if !ok {
+		t.Fatalf("expected *UnpackError, got %T", errs[0])
+	}
+	if unpackErr.Pos != properties[1].NamePos {
+		t.Errorf("expected error positioned at %q's token %v, got %v", "typo", properties[1].NamePos, unpackErr.Pos)
+	}
+	if dst.S != "abc" {
+		t.Errorf("expected matching fields to still be unpacked, got S = %q", dst.S)
+	}
+}
+
+func TestUnpackPropertiesStrictNested(t *testing.T) {
+	properties := parseModuleProperties(t, `
+		m {
+			nested: {
+				s: "abc",
+				typo: "oops",
+			},
+		}
+	`)
+
+	dst := &struct {
+		Nested struct {
+			S string
+		}
+	}{}
+
+	_, errs := UnpackPropertiesWithOptions(&UnpackOptions{Strict: true}, properties, dst)
+	if len(errs) != 1 {
+		t.Fatalf("expected exactly one error, got %v", errs)
+	}
+	if _, ok := errs[0].(*UnpackError); !ok {
+		t.Fatalf("expected *UnpackError, got %T", errs[0])
+	}
+}
+
+func TestUnpackPropertiesStrictMap(t *testing.T) {
+	// A strict-mode error from unpacking a map[string]struct{...} element
+	// must be reported exactly once, the same as any other nested struct.
+	properties := parseModuleProperties(t, `
+		m {
+			dict: {
+				arm: {
+					cflags: ["-DARM"],
+					typo: "oops",
+				},
+			},
+		}
+	`)
+
+	dst := &struct {
+		Dict map[string]struct {
+			Cflags []string
+		}
+	}{}
+
+	_, errs := UnpackPropertiesWithOptions(&UnpackOptions{Strict: true}, properties, dst)
+	if len(errs) != 1 {
+		t.Fatalf("expected exactly one error, got %v", errs)
+	}
+	if _, ok := errs[0].(*UnpackError); !ok {
+		t.Fatalf("expected *UnpackError, got %T", errs[0])
+	}
+}
+
+func TestUnpackPropertiesStrictMultipleObjects(t *testing.T) {
+	// A property consumed by any one of several objects passed to the same
+	// call must not be reported as unknown just because another of the
+	// objects didn't have a matching field for it.
+	properties := parseModuleProperties(t, `
+		m {
+			a: "1",
+			b: "2",
+		}
+	`)
+
+	dstA := &struct{ A string }{}
+	dstB := &struct{ B string }{}
+
+	_, errs := UnpackPropertiesWithOptions(&UnpackOptions{Strict: true}, properties, dstA, dstB)
+	if len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
+
+func TestUnpackPropertiesDeprecated(t *testing.T) {
+	properties := parseModuleProperties(t, `
+		m {
+			old: "abc",
+		}
+	`)
+
+	dst := &struct {
+		Old string `blueprint:"deprecated:'use new instead'"`
+	}{}
+
+	var warnings []string
+	options := &UnpackOptions{
+		WarnFunc: func(pos scanner.Position, msg string) {
+			warnings = append(warnings, msg)
+		},
+	}
+
+	if _, errs := UnpackPropertiesWithOptions(options, properties, dst); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if dst.Old != "abc" {
+		t.Errorf("expected Old to still be unpacked, got %q", dst.Old)
+	}
+	if len(warnings) != 1 || warnings[0] != `old is deprecated: use new instead` {
+		t.Errorf("expected one deprecation warning, got %v", warnings)
+	}
+}
+
+func TestUnpackPropertiesDeprecatedByName(t *testing.T) {
+	properties := parseModuleProperties(t, `
+		m {
+			old: "abc",
+		}
+	`)
+
+	// Strict mode would otherwise reject "old" outright, since it has no
+	// matching field in dst; listing it in Deprecated instead downgrades it
+	// to a warning.
+	dst := &struct{}{}
+
+	var warnings []string
+	options := &UnpackOptions{
+		Strict:     true,
+		Deprecated: map[string]string{"old": "removed, no replacement"},
+		WarnFunc: func(pos scanner.Position, msg string) {
+			warnings = append(warnings, msg)
+		},
+	}
+
+	if _, errs := UnpackPropertiesWithOptions(options, properties, dst); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(warnings) != 1 || warnings[0] != `old is deprecated: removed, no replacement` {
+		t.Errorf("expected one deprecation warning, got %v", warnings)
+	}
+}
+
+func TestUnpackPropertiesNilOptionsBackCompat(t *testing.T) {
+	properties := parseModuleProperties(t, `
+		m {
+			s: "abc",
+			typo: "oops",
+		}
+	`)
+
+	dst := &struct {
+		S string
+	}{}
+
+	withNilOptions, errsWithOptions := UnpackPropertiesWithOptions(nil, properties, dst)
+
+	dst2 := &struct {
+		S string
+	}{}
+	plain, errsPlain := UnpackProperties(properties, dst2)
+
+	if !reflect.DeepEqual(withNilOptions, plain) || !reflect.DeepEqual(errsWithOptions, errsPlain) {
+		t.Errorf("UnpackPropertiesWithOptions(nil, ...) should behave exactly like UnpackProperties")
+	}
+	if !reflect.DeepEqual(dst, dst2) {
+		t.Errorf("UnpackPropertiesWithOptions(nil, ...) should behave exactly like UnpackProperties")
+	}
+}
+
+func TestClonePropertiesUnexportedField(t *testing.T) {
+	type properties struct {
+		Name string
+		priv string
+	}
+
+	src := properties{Name: "foo", priv: "bar"}
+
+	if got := CloneProperties(reflect.ValueOf(src)).Interface().(*properties); got.Name != "foo" {
+		t.Errorf("expected CloneProperties to copy exported fields, got %+v", got)
+	}
+
+	if got := CloneEmptyProperties(reflect.ValueOf(src)).Interface().(*properties); got.Name != "" {
+		t.Errorf("expected CloneEmptyProperties to leave exported fields zeroed, got %+v", got)
+	}
+}